@@ -9,10 +9,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/la5nta/pat/cfg"
-	"github.com/la5nta/pat/internal/debug"
-	"log"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/harenber/ptc-go/v2/pactor"
@@ -34,6 +33,11 @@ var (
 
 	// Context cancellation function for aborting while dialing.
 	dialCancelFunc func() = func() {}
+
+	// connectMu serializes Connect so a manually triggered connect and a
+	// ConnectScheduler retry (see connect_scheduler.go) can never dial
+	// concurrently and race on dialing/dialCancelFunc/adTNC above.
+	connectMu sync.Mutex
 )
 
 func hasSSID(str string) bool { return strings.Contains(str, "-") }
@@ -54,13 +58,16 @@ func Connect(connectStr string) (success bool) {
 		return Connect(aliased)
 	}
 
+	connectMu.Lock()
+	defer connectMu.Unlock()
+
 	// Hack around bug in frontend which may occur if the status updates too quickly.
 	defer func() { time.Sleep(time.Second); websocketHub.UpdateStatus() }()
 
-	debug.Printf("connectStr: %s", connectStr)
+	logger.Debugf("connectStr: %s", connectStr)
 	url, err := transport.ParseURL(connectStr)
 	if err != nil {
-		log.Println(err)
+		logger.Errorf("%s", err)
 		return false
 	}
 
@@ -68,7 +75,7 @@ func Connect(connectStr string) (success bool) {
 	switch url.Scheme {
 	case MethodArdop:
 		if err := initArdopTNC(); err != nil {
-			log.Println(err)
+			logger.Errorf("%s", err)
 			return
 		}
 	case MethodPactor:
@@ -77,17 +84,17 @@ func Connect(connectStr string) (success bool) {
 			ptCmdInit = strings.Join(val, "\n")
 		}
 		if err := initPactorModem(ptCmdInit); err != nil {
-			log.Println(err)
+			logger.Errorf("%s", err)
 			return
 		}
 	case MethodVaraHF:
 		if err := initVaraModem(varaHFModem, MethodVaraHF, config.VaraHF); err != nil {
-			log.Println(err)
+			logger.Errorf("%s", err)
 			return
 		}
 	case MethodVaraFM:
 		if err := initVaraModem(varaFMModem, MethodVaraFM, config.VaraFM); err != nil {
-			log.Println(err)
+			logger.Errorf("%s", err)
 			return
 		}
 	}
@@ -120,13 +127,13 @@ func Connect(connectStr string) (success bool) {
 	}
 	if radioOnly {
 		if hasSSID(fOptions.MyCall) {
-			log.Println("Radio Only does not support callsign with SSID")
+			logger.Warnf("Radio Only does not support callsign with SSID")
 			return
 		}
 
 		switch url.Scheme {
 		case MethodAX25, MethodSerialTNC:
-			log.Printf("Radio-Only is not available for %s", url.Scheme)
+			logger.Warnf("Radio-Only is not available for %s", url.Scheme)
 			return
 		default:
 			url.SetUser(url.User.Username() + "-T")
@@ -138,7 +145,7 @@ func Connect(connectStr string) (success bool) {
 	if freq := url.Params.Get("freq"); freq != "" {
 		revertFreq, err = qsy(url.Scheme, freq)
 		if err != nil {
-			log.Printf("Unable to QSY: %s", err)
+			logger.Errorf("Unable to QSY: %s", err)
 			return
 		}
 		defer revertFreq()
@@ -163,7 +170,7 @@ func Connect(connectStr string) (success bool) {
 	dialing = url
 	websocketHub.UpdateStatus()
 
-	log.Printf("Connecting to %s (%s)...", url.Target, url.Scheme)
+	logger.Infof("Connecting to %s (%s)...", url.Target, url.Scheme)
 	conn, err := transport.DialURLContext(ctx, url)
 
 	// Signal web gui that we are no longer dialing
@@ -174,18 +181,18 @@ func Connect(connectStr string) (success bool) {
 
 	switch {
 	case errors.Is(err, context.Canceled):
-		log.Printf("Connect cancelled")
+		logger.Infof("Connect cancelled")
 		return
 	case err != nil:
-		log.Printf("Unable to establish connection to remote: %s", err)
+		logger.Errorf("Unable to establish connection to remote: %s", err)
 		return
 	}
 
 	err = exchange(conn, url.Target, false)
 	if err != nil {
-		log.Printf("Exchange failed: %s", err)
+		logger.Errorf("Exchange failed: %s", err)
 	} else {
-		log.Println("Disconnected.")
+		logger.Infof("Disconnected.")
 		success = true
 	}
 
@@ -201,7 +208,7 @@ func qsy(method, addr string) (revert func(), err error) {
 		return noop, fmt.Errorf("hamlib rig '%s' not loaded", rigName)
 	}
 
-	log.Printf("QSY %s: %s", method, addr)
+	logger.Infof("QSY %s: %s", method, addr, Fields{"scheme": method})
 	_, oldFreq, err := setFreq(rig, addr)
 	if err != nil {
 		return noop, err
@@ -210,7 +217,7 @@ func qsy(method, addr string) (revert func(), err error) {
 	time.Sleep(3 * time.Second)
 	return func() {
 		time.Sleep(time.Second)
-		log.Printf("QSX %s: %.3f", method, float64(oldFreq)/1e3)
+		logger.Infof("QSX %s: %.3f", method, float64(oldFreq)/1e3, Fields{"scheme": method})
 		rig.SetFreq(oldFreq)
 	}, nil
 }
@@ -220,10 +227,10 @@ func waitBusy(b transport.BusyChannelChecker) {
 
 	for b.Busy() {
 		if !printed && fOptions.IgnoreBusy {
-			log.Println("Ignoring busy channel!")
+			logger.Warnf("Ignoring busy channel!")
 			break
 		} else if !printed {
-			log.Println("Waiting for clear channel...")
+			logger.Infof("Waiting for clear channel...")
 			printed = true
 		}
 		time.Sleep(300 * time.Millisecond)
@@ -258,7 +265,11 @@ func initArdopTNC() error {
 	if v, err := adTNC.Version(); err != nil {
 		return fmt.Errorf("ARDOP TNC initialization failed: %s", err)
 	} else {
-		log.Printf("ARDOP TNC (%s) initialized", v)
+		logger.Infof("ARDOP TNC (%s) initialized", v, Fields{
+			"scheme": MethodArdop,
+			"addr":   config.Ardop.Addr,
+			"mycall": fOptions.MyCall,
+		})
 	}
 
 	transport.RegisterDialer(MethodArdop, adTNC)
@@ -288,6 +299,12 @@ func initPactorModem(cmdlineinit string) error {
 
 	transport.RegisterDialer(MethodPactor, pModem)
 
+	logger.Infof("Pactor modem initialized", Fields{
+		"scheme": MethodPactor,
+		"addr":   config.Pactor.Path,
+		"mycall": fOptions.MyCall,
+	})
+
 	return nil
 }
 
@@ -308,6 +325,12 @@ func initVaraModem(vModem *vara.Modem, scheme string, conf cfg.VaraConfig) error
 
 	transport.RegisterDialer(scheme, vModem)
 
+	logger.Infof("%s modem initialized", scheme, Fields{
+		"scheme": scheme,
+		"addr":   conf.Host,
+		"mycall": fOptions.MyCall,
+	})
+
 	if !conf.PTTControl {
 		return nil
 	}
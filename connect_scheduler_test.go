@@ -0,0 +1,57 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNextInterval(t *testing.T) {
+	cases := []struct {
+		name    string
+		current time.Duration
+		backoff float64
+		want    time.Duration
+	}{
+		{"first failure, no backoff configured", 0, 0, time.Minute},
+		{"first failure, 1.5x backoff", 0, 1.5, 90 * time.Second},
+		{"subsequent failure, 1.5x backoff", 90 * time.Second, 1.5, 135 * time.Second},
+		{"no backoff configured keeps current interval", 5 * time.Minute, 0, 5 * time.Minute},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := nextInterval(c.current, c.backoff); got != c.want {
+				t.Errorf("nextInterval(%s, %v) = %s, want %s", c.current, c.backoff, got, c.want)
+			}
+		})
+	}
+}
+
+func TestScheduleEntryExpired(t *testing.T) {
+	now := time.Date(2026, 7, 29, 12, 0, 0, 0, time.UTC)
+
+	e := ScheduleEntry{Deadline: now.Add(-time.Second)}
+	if !e.expired(now) {
+		t.Error("expected an entry past its deadline to be expired")
+	}
+
+	e.Deadline = now.Add(time.Second)
+	if e.expired(now) {
+		t.Error("expected an entry before its deadline to not be expired")
+	}
+}
+
+func TestScheduleEntryExhausted(t *testing.T) {
+	e := ScheduleEntry{MaxAttempts: 3, Attempts: 3}
+	if !e.exhausted() {
+		t.Error("expected an entry at MaxAttempts to be exhausted")
+	}
+
+	e.MaxAttempts = 0
+	if e.exhausted() {
+		t.Error("expected MaxAttempts <= 0 to mean unlimited attempts")
+	}
+}
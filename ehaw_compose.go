@@ -5,12 +5,17 @@
 // A portable Winlink client for amateur radio email.
 
 /*
-	ehaw_compose.go creates an application specific interface for the
+	ehaw_compose.go and its siblings (ehaw_store.go, ehaw_http.go,
+	ehaw_moderator.go) create an application specific interface for the
 	Emergency Health & Welfare Message Service (eHaW) created by Bob
-	Segrest [KO2F].  Implementation of this interface is limited to this
-	file, 5 lines of code to add a 'Process' eHaW messages in main.go,
-	and a sample eHaW_configuration.json file to be manually edited and
-	deployed in the users pat folder.
+	Segrest [KO2F]. Implementation
+	of this interface is limited to these files, 5 lines of code to add a
+	'Process' eHaW messages in main.go, a sample eHaW_configuration.json
+	file to be manually edited and deployed in the users pat folder, and
+	one call to RegisterHTTPHandlers (see http_routes.go) wherever Pat's
+	web server builds its *http.ServeMux — that file isn't part of this
+	tree, so this last line is the one piece of wiring this series can't
+	finish on its own.
 
 	There is no expectation that this feature will be merged into the
 	main PAT distribution pool.
@@ -19,26 +24,48 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"context"
-	"database/sql"
 	"encoding/json"
 	"fmt"
-	"log"
 	"os"
 	"strconv"
 	"strings"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/la5nta/wl2k-go/fbb"
 )
 
+// ehawConfig is parsed from eHaW_config.json. Driver selects which
+// EhawStore implementation backs the message queue; the remaining fields
+// are only read by the matching driver.
 type ehawConfig struct {
+	Driver string `json:"driver"` // "mysql" (default), "postgres" or "sqlite"
+
 	Mysql_user     string `json:"mysql_user"`
 	Mysql_password string `json:"mysql_password"`
 	Mysql_host     string `json:"mysql_host"`
 	Mysql_database string `json:"mysql_database"`
+
+	Postgres_user     string `json:"postgres_user"`
+	Postgres_password string `json:"postgres_password"`
+	Postgres_host     string `json:"postgres_host"`
+	Postgres_database string `json:"postgres_database"`
+
+	SQLitePath string `json:"sqlite_path"`
+
+	// HTTPAuthToken, when set, is required as a Bearer token on POST
+	// /api/ehaw/submit. WebhookURL, when set, is POSTed the eHaW id, MID
+	// and status whenever a message transitions Accepted->Sent.
+	HTTPAuthToken string `json:"http_auth_token"`
+	WebhookURL    string `json:"webhook_url"`
+
+	// WebModeration drives Accept/Decline/Ignore from the pending-message
+	// panel at GET /ehaw/pending instead of blocking on stdin, for
+	// headless deployments. It does not go over Pat's websocketHub:
+	// WebModerator (see ehaw_moderator.go) publishes each pending message
+	// for the panel to poll at GET /api/ehaw/pending and waits for the
+	// decision the panel posts to POST /api/ehaw/decision.
+	WebModeration bool `json:"web_moderation"`
 }
 
 type msgStruct struct {
@@ -51,9 +78,8 @@ type msgQueue struct {
 	msgWinlinkId string
 }
 
-var db *sql.DB
-var err error
 var ehawCfg ehawConfig
+var ehawStore EhawStore
 
 func getEhawCfg() error {
 	// read the json file
@@ -65,89 +91,81 @@ func getEhawCfg() error {
 	}
 	// parse the values into ehawCfg
 	eCfg := make(map[string]ehawConfig)
-	err = json.Unmarshal(data, &eCfg)
-	if err != nil {
+	if err := json.Unmarshal(data, &eCfg); err != nil {
 		return err
 	}
 	// and copy them into the eHaW Config structure
 	ehawCfg = eCfg["eHaW"]
-	return err
-}
-
-func getEhawEmail() ([]msgStruct, error) {
-	// open the eHaW database
-	conStr := ehawCfg.Mysql_user + ":" +
-		ehawCfg.Mysql_password + "@" +
-		ehawCfg.Mysql_host + "/" +
-		ehawCfg.Mysql_database
-	db, err = sql.Open("mysql", conStr)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	defer db.Close()
-	// read the formatted messages from the eHaw Database
-	rows, err := db.Query("SELECT * FROM buildMsg ORDER BY msgId")
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	// structure the eHaW submitted messages for Processing in PAT
-	var msgs []msgStruct
-	for rows.Next() {
-		var msg msgStruct
-		if err := rows.Scan(&msg.msgId, &msg.msgSubject, &msg.msgTo, &msg.msgBody); err != nil {
-			return msgs, err
-		}
-		msgs = append(msgs, msg)
-	}
-	if err = rows.Err(); err != nil {
-		return msgs, err
-	}
-	return msgs, nil
+	return nil
 }
 
 func processEhawEmail(ctx context.Context, args []string) {
 	var msgs []msgStruct
 	// load the eHaW configuration information
-	err := getEhawCfg()
-	if err != nil {
-		log.Println(err)
+	if err := getEhawCfg(); err != nil {
+		logger.Errorf("%s", err)
 		fmt.Println("Failed to load eHaW config!")
+		return
+	}
+	// open (or reuse) the configured eHaW store
+	if ehawStore == nil {
+		store, err := newEhawStore(ehawCfg)
+		if err != nil {
+			logger.Errorf("%s", err)
+			fmt.Println("Failed to open the eHaW store!")
+			return
+		}
+		ehawStore = store
+	}
+	// pick the moderator: CLIModerator by default, or the web GUI if
+	// configured (see ehaw_moderator.go)
+	if ehawCfg.WebModeration {
+		if _, ok := ehawModerator.(*WebModerator); !ok {
+			ehawModerator = newWebModerator()
+		}
 	}
 	// get submitted email msgs from eHaW
-	msgs, err = getEhawEmail()
+	msgs, err := ehawStore.getEhawEmail()
 	if err != nil {
-		log.Println(err)
+		logger.Errorf("%s", err)
 		fmt.Println(" There was a problem getting messages from the eHaW database")
 		return
 	}
+	// drain any messages submitted through the HTTP intake endpoint
+	//  (see ehaw_http.go) alongside the ones polled from the store
+	for {
+		select {
+		case m := <-ehawIntake:
+			msgs = append(msgs, m)
+			continue
+		default:
+		}
+		break
+	}
 	if len(msgs) < 1 {
 		fmt.Println("There are no submitted eHaW messages to process at this time.")
 	} else {
 		// process each eHaW submitted message
 		for msg := 0; msg < len(msgs); msg++ {
-			// display the message for Moderation by a PAT operator
-			fmt.Println("\n\r\r")
-			fmt.Println("eHaW Id: ", msgs[msg].msgId)
-			fmt.Println("Subject: ", msgs[msg].msgSubject)
-			fmt.Println("To:      ", msgs[msg].msgTo, "\n\r\r")
-			fmt.Println(msgs[msg].msgBody)
-			fmt.Println("\n\r")
-			// the PAT operator decides what happens next
-			fmt.Print("Accept, Decline, or Ignore: ")
-			reader := bufio.NewReader(os.Stdin)
-			input, err := reader.ReadString('\n')
+			// let the configured Moderator decide what happens next: the
+			//  CLI moderator displays the message and blocks on stdin,
+			//  the web moderator publishes it to ehawPending for the
+			//  /ehaw/pending panel to poll instead (see ehaw_moderator.go)
+			input, err := ehawModerator.Decide(msgs[msg])
 			if err != nil {
-				log.Println(err)
+				logger.Errorf("%s", err)
 			}
-			input = strings.TrimSpace(input)
-			input = strings.ToUpper(input)
 			switch input {
 			case "D", "DECLINE":
 				// if declined, status is updated in the eHaW database
 				//  and the submitted message is effectively closed
 				// add code here to mark the eHaW message record as Declined
-				updateEhawMsg(msgs[msg].msgId, "Declined", "")
+				// (messages submitted over HTTP have no msgQueue row to update)
+				if msgs[msg].msgId >= 0 {
+					if err := ehawStore.updateEhawMsg(msgs[msg].msgId, "Declined", ""); err != nil {
+						logger.Errorf("%s", err)
+					}
+				}
 				fmt.Println("this message has been Declined and will not be sent.")
 			case "I", "IGNORE":
 				// if Ignored, no action is taken and the process move forward to
@@ -166,20 +184,27 @@ func processEhawEmail(ctx context.Context, args []string) {
 				//  PAT cli interface
 				err = ehawComposeMessage(msgs[msg].msgSubject, msgs[msg].msgTo, msgs[msg].msgBody)
 				if err != nil {
-					log.Println(err)
+					logger.Errorf("%s", err)
 				}
 				// after the message is created, we immediately build a new list of MIDs
 				//  in the PAT out folder
 				afterList, err := getMsgBoxList("out")
 				if err != nil {
-					log.Println(err)
+					logger.Errorf("%s", err)
 				}
 				// by comparing the 2 out folder lists we can identify the Message ID (MID)
 				// created for the new message
 				newMsgId := getMsgIds(beforeList, afterList)
 				// finally, we can change the message status to Accepted and store the MID
-				//  in eHaW for reference in a future process cycle
-				updateEhawMsg(msgs[msg].msgId, "Accepted", newMsgId[0])
+				//  for reference in a future process cycle: in the eHaW database for
+				//  store-backed messages, or in-memory for ones submitted over HTTP
+				if msgs[msg].msgId >= 0 {
+					if err := ehawStore.updateEhawMsg(msgs[msg].msgId, "Accepted", newMsgId[0]); err != nil {
+						logger.Errorf("%s", err)
+					}
+				} else {
+					ehawHTTPAccepted.Store(msgs[msg].msgId, newMsgId[0])
+				}
 			default:
 				fmt.Println("Invalid action, ignoring this message for now.")
 			}
@@ -188,10 +213,16 @@ func processEhawEmail(ctx context.Context, args []string) {
 	// after all the messages are processed, we read a list previously Accepted
 	//  messages from eHaW, look for matching MIDs in the PAT sent folder, and
 	//  for this that match set the message status in eHaW to sent
-	newMsgsSent, err := updateSentEhawMsgStatus()
+	newMsgsSent, err := ehawStore.updateSentEhawMsgStatus()
 	if err != nil {
 		fmt.Println("Problem updating eHaW sent message status")
 	}
+	// ...and do the same for messages accepted via the HTTP intake endpoint
+	httpMsgsSent, err := pollEhawHTTPSent()
+	if err != nil {
+		fmt.Println("Problem updating sent status for HTTP-submitted eHaW messages")
+	}
+	newMsgsSent += httpMsgsSent
 
 	// if sent messages were updated, tell the PAT operator
 	if newMsgsSent > 0 {
@@ -200,96 +231,6 @@ func processEhawEmail(ctx context.Context, args []string) {
 
 }
 
-func updateEhawMsg(Id int, status string, newMsgId string) {
-	// open the eHaW database
-	conStr := ehawCfg.Mysql_user + ":" +
-		ehawCfg.Mysql_password + "@" +
-		ehawCfg.Mysql_host + "/" +
-		ehawCfg.Mysql_database
-	db, err = sql.Open("mysql", conStr)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	defer db.Close()
-	// yes its ugly SQL, but this is the way MySQL updates a record
-	stmt, err := db.Prepare("UPDATE msgQueue SET msgStatus=?, msgWinlinkId=? WHERE msgId =?")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	res, err := stmt.Exec(status, newMsgId, strconv.Itoa(Id))
-	if err != nil {
-		log.Println(err)
-		return
-	}
-	log.Println(res, "eHaW record updated")
-}
-
-func updateSentEhawMsgStatus() (int, error) {
-	newEhawMsgsSentCount := 0
-	// open the eHaW database
-	conStr := ehawCfg.Mysql_user + ":" +
-		ehawCfg.Mysql_password + "@" +
-		ehawCfg.Mysql_host + "/" +
-		ehawCfg.Mysql_database
-	db, err = sql.Open("mysql", conStr)
-	if err != nil {
-		fmt.Println(err.Error())
-	}
-	defer db.Close()
-	// get a list of eHaW messages that were previously accepted
-	rows, err := db.Query("SELECT msgId, msgWinlinkId FROM msgQueue WHERE msgStatus='Accepted' AND msgWinlinkId IS NOT NULL")
-	if err != nil {
-		return 0, err
-	}
-	defer rows.Close()
-	// now count the Accepted messages and create a slice of the messages returned
-	var accepted []msgQueue
-	rowCount := 0
-	for rows.Next() {
-		// get the record
-		var msg msgQueue
-		if err := rows.Scan(&msg.msgId, &msg.msgWinlinkId); err != nil {
-			return 0, err
-		}
-		// save the values later
-		accepted = append(accepted, msg)
-		// and count the IDs to look for
-		rowCount += 1
-	}
-	// if there are IDs to look for
-	if rowCount > 0 {
-		// get a list of PAT messages that have been sent
-		var sentMsgs []string
-		sentMsgs, err = getMsgBoxList("sent")
-
-		// for each previously accepted eHaW message
-		for ehaw := 0; ehaw < len(accepted); ehaw++ {
-			// see if the accepted WinlinkId is in the sent list
-			for sent := 0; sent < len(sentMsgs); sent++ {
-				// if we find a match
-				if accepted[ehaw].msgWinlinkId == sentMsgs[sent] {
-					// prepare an update statement (its a Go thing...)
-					stmt, err := db.Prepare("UPDATE msgQueue SET msgStatus=? WHERE msgId=?")
-					if err != nil {
-						log.Println(err)
-						return newEhawMsgsSentCount, err
-					}
-					// and use the eHaW msgId to change Approved to Sent
-					res, err := stmt.Exec("Sent", strconv.Itoa(accepted[ehaw].msgId))
-					if err != nil {
-						log.Println(err, res)
-						return newEhawMsgsSentCount, err
-					}
-					newEhawMsgsSentCount++
-				}
-			}
-		}
-	}
-	// when done, return the count
-	return newEhawMsgsSentCount, err
-}
-
 func getMsgBoxList(msgBox string) ([]string, error) {
 	var oMsgs []string
 	// open the folder (out, sent) and get a list of message files
@@ -344,5 +285,5 @@ func ehawComposeMessage(subject string, recipients string, body string) error {
 	msg.SetSubject(subject)
 	msg.SetBody(string(body))
 	postMessage(msg)
-	return err
+	return nil
 }
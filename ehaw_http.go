@@ -0,0 +1,252 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// ehawIntake carries messages submitted through the HTTP intake endpoint
+// into processEhawEmail, so eHaW doesn't need a MySQL server to operate:
+// the moderation loop drains this channel the same way it drains rows
+// from getEhawEmail.
+var ehawIntake = make(chan msgStruct, 32)
+
+// ehawNextIntakeID hands out synthetic, strictly negative message IDs for
+// HTTP submissions so they never collide with a store's auto-incrementing
+// buildMsg.msgId.
+var ehawNextIntakeID int64
+
+// ehawIntakeExternalIDs remembers the caller-supplied external_id for
+// messages that arrived over HTTP, keyed by the synthetic msgId, so the
+// Accepted->Sent webhook can report it back.
+var ehawIntakeExternalIDs sync.Map // map[int]string
+
+// ehawHTTPAccepted tracks the Winlink MID assigned to each HTTP-submitted
+// message that was Accepted, keyed by its synthetic msgId, until
+// pollEhawHTTPSent sees a matching file in the sent folder. Messages
+// backed by a store instead track this in the msgQueue table.
+var ehawHTTPAccepted sync.Map // map[int]string
+
+// ehawSubmission is the JSON body accepted by POST /api/ehaw/submit.
+type ehawSubmission struct {
+	Subject    string `json:"subject"`
+	To         string `json:"to"`
+	Body       string `json:"body"`
+	ExternalID string `json:"external_id"`
+}
+
+// registerEhawHTTPHandlers wires the eHaW intake endpoint, the web
+// moderator's pending/decision endpoints and its panel onto mux. Called
+// from RegisterHTTPHandlers, see http_routes.go.
+func registerEhawHTTPHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/api/ehaw/submit", handleEhawSubmit)
+	mux.HandleFunc("/api/ehaw/pending", handleEhawPending)
+	mux.HandleFunc("/api/ehaw/decision", handleEhawDecision)
+	mux.HandleFunc("/ehaw/pending", handleEhawPendingPanel)
+}
+
+func handleEhawSubmit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if token := ehawCfg.HTTPAuthToken; token != "" && r.Header.Get("Authorization") != "Bearer "+token {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var sub ehawSubmission
+	if err := json.NewDecoder(r.Body).Decode(&sub); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sub.Subject == "" || sub.To == "" || sub.Body == "" {
+		http.Error(w, "subject, to and body are required", http.StatusBadRequest)
+		return
+	}
+
+	id := int(atomic.AddInt64(&ehawNextIntakeID, -1))
+	if sub.ExternalID != "" {
+		ehawIntakeExternalIDs.Store(id, sub.ExternalID)
+	}
+
+	msg := msgStruct{msgId: id, msgSubject: sub.Subject, msgTo: sub.To, msgBody: sub.Body}
+	select {
+	case ehawIntake <- msg:
+	default:
+		http.Error(w, "eHaW intake queue is full, try again later", http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(struct {
+		ID int `json:"id"`
+	}{id})
+}
+
+// handleEhawPending serves the message currently awaiting a decision from
+// WebModerator.Decide (see ehaw_moderator.go), for ehawPendingPanelHTML to
+// poll.
+func handleEhawPending(w http.ResponseWriter, r *http.Request) {
+	var pending []ehawPendingEvent
+	ehawPending.Range(func(_, v interface{}) bool {
+		pending = append(pending, v.(ehawPendingEvent))
+		return true
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pending)
+}
+
+// handleEhawDecision resolves a pending message by feeding the posted
+// decision into the running WebModerator, waking the Decide call that's
+// blocked on it.
+func handleEhawDecision(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	wm, ok := ehawModerator.(*WebModerator)
+	if !ok {
+		http.Error(w, "eHaW is not running in web moderation mode", http.StatusConflict)
+		return
+	}
+
+	var d ehawDecision
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	wm.HandleDecision(d)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleEhawPendingPanel serves a minimal self-contained pending-message
+// panel: it polls GET /api/ehaw/pending and posts the operator's choice to
+// POST /api/ehaw/decision. It isn't styled to match Pat's own web GUI
+// (those templates live outside this series), but it gives WebModeration
+// an actual frontend instead of leaving the panel undocumented.
+func handleEhawPendingPanel(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, ehawPendingPanelHTML)
+}
+
+const ehawPendingPanelHTML = `<!DOCTYPE html>
+<html>
+<head><title>eHaW pending messages</title></head>
+<body>
+<h1>eHaW pending messages</h1>
+<div id="pending">Loading...</div>
+<script>
+function decide(id, action) {
+	fetch('/api/ehaw/decision', {
+		method: 'POST',
+		headers: {'Content-Type': 'application/json'},
+		body: JSON.stringify({msg_id: id, action: action}),
+	}).then(refresh);
+}
+
+function refresh() {
+	fetch('/api/ehaw/pending').then(function(r) { return r.json(); }).then(function(msgs) {
+		var el = document.getElementById('pending');
+		el.innerHTML = '';
+		(msgs || []).forEach(function(msg) {
+			var div = document.createElement('div');
+			div.innerHTML = '<h3>' + msg.subject + '</h3><p>To: ' + msg.to + '</p><pre>' + msg.body + '</pre>' +
+				'<button onclick="decide(' + msg.msg_id + ', \'ACCEPT\')">Accept</button> ' +
+				'<button onclick="decide(' + msg.msg_id + ', \'DECLINE\')">Decline</button> ' +
+				'<button onclick="decide(' + msg.msg_id + ', \'IGNORE\')">Ignore</button>';
+			el.appendChild(div);
+		});
+		if (!msgs || !msgs.length) {
+			el.textContent = 'Nothing pending.';
+		}
+	});
+}
+
+refresh();
+setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
+
+// notifyEhawWebhook POSTs the eHaW id, the Winlink MID it was sent under,
+// and the final status back to the webhook URL configured in
+// eHaW_config.json, so a system that submitted a message over HTTP is
+// notified of the Accepted->Sent transition without polling.
+func notifyEhawWebhook(id int, mid, status string) {
+	if ehawCfg.WebhookURL == "" {
+		return
+	}
+	var externalID string
+	if v, ok := ehawIntakeExternalIDs.Load(id); ok {
+		externalID = v.(string)
+	}
+	payload, err := json.Marshal(struct {
+		ID         int    `json:"id"`
+		ExternalID string `json:"external_id,omitempty"`
+		MID        string `json:"mid"`
+		Status     string `json:"status"`
+	}{id, externalID, mid, status})
+	if err != nil {
+		logger.Errorf("%s", err)
+		return
+	}
+
+	go func() {
+		resp, err := http.Post(ehawCfg.WebhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			logger.Errorf("eHaW: webhook delivery failed: %s", err)
+			return
+		}
+		resp.Body.Close()
+		if id < 0 {
+			ehawIntakeExternalIDs.Delete(id)
+		}
+	}()
+}
+
+// pollEhawHTTPSent mirrors sqlEhawStore.updateSentEhawMsgStatus for
+// messages that arrived over HTTP and therefore have no msgQueue row: it
+// checks the PAT sent folder for each Accepted MID and fires the webhook
+// once a match shows up.
+func pollEhawHTTPSent() (int, error) {
+	var pending []msgQueue
+	ehawHTTPAccepted.Range(func(k, v interface{}) bool {
+		pending = append(pending, msgQueue{msgId: k.(int), msgWinlinkId: v.(string)})
+		return true
+	})
+	if len(pending) == 0 {
+		return 0, nil
+	}
+
+	sentMsgs, err := getMsgBoxList("sent")
+	if err != nil {
+		return 0, err
+	}
+	sent := make(map[string]struct{}, len(sentMsgs))
+	for _, mid := range sentMsgs {
+		sent[mid] = struct{}{}
+	}
+
+	var count int
+	for _, msg := range pending {
+		if _, ok := sent[msg.msgWinlinkId]; !ok {
+			continue
+		}
+		ehawHTTPAccepted.Delete(msg.msgId)
+		notifyEhawWebhook(msg.msgId, msg.msgWinlinkId, "Sent")
+		count++
+	}
+	return count, nil
+}
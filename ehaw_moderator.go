@@ -0,0 +1,114 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Moderator decides the fate of a pending eHaW message: Accept, Decline
+// or Ignore. CLIModerator is the original behavior, blocking on stdin;
+// WebModerator is driven by decisions arriving over the eHaW HTTP API
+// (see ehaw_http.go) so Pat can run headless (systemd, Docker, a remote
+// server with no terminal).
+type Moderator interface {
+	// Decide presents msg for moderation and blocks until an action
+	// ("ACCEPT", "DECLINE", "IGNORE" or anything else, treated as
+	// invalid) has been chosen.
+	Decide(msg msgStruct) (action string, err error)
+}
+
+// ehawModerator is the Moderator used by processEhawEmail. It defaults to
+// CLIModerator; set ehawCfg.WebModeration to drive moderation from the
+// /ehaw/pending web panel (see ehaw_http.go) instead.
+var ehawModerator Moderator = CLIModerator{}
+
+// CLIModerator prompts the PAT operator on stdin, exactly as
+// processEhawEmail used to do inline.
+type CLIModerator struct{}
+
+func (CLIModerator) Decide(msg msgStruct) (string, error) {
+	fmt.Println("\n\r\r")
+	fmt.Println("eHaW Id: ", msg.msgId)
+	fmt.Println("Subject: ", msg.msgSubject)
+	fmt.Println("To:      ", msg.msgTo, "\n\r\r")
+	fmt.Println(msg.msgBody)
+	fmt.Println("\n\r")
+	fmt.Print("Accept, Decline, or Ignore: ")
+
+	reader := bufio.NewReader(os.Stdin)
+	input, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(strings.TrimSpace(input)), nil
+}
+
+// ehawDecision is the body POST /api/ehaw/decision accepts to resolve a
+// pending eHaW message: {"msg_id": ..., "action": "accept"|"decline"|"ignore"}.
+type ehawDecision struct {
+	MsgId  int    `json:"msg_id"`
+	Action string `json:"action"`
+}
+
+// ehawPendingEvent is what GET /api/ehaw/pending returns so the pending-
+// message panel (see ehawPendingPanelHTML in ehaw_http.go) has something
+// to render and a msg_id to send back in a decision.
+type ehawPendingEvent struct {
+	MsgId   int    `json:"msg_id"`
+	Subject string `json:"subject"`
+	To      string `json:"to"`
+	Body    string `json:"body"`
+}
+
+// ehawPending holds the message currently awaiting a web moderator's
+// decision, keyed by msgId, so GET /api/ehaw/pending can serve it to the
+// panel without Decide's caller needing to know the HTTP layer exists.
+var ehawPending sync.Map // map[int]ehawPendingEvent
+
+// WebModerator publishes the pending eHaW message to ehawPending and
+// waits for a matching decision to arrive over POST /api/ehaw/decision
+// instead of blocking on a terminal.
+type WebModerator struct {
+	decisions chan ehawDecision
+}
+
+// newWebModerator returns a WebModerator ready to have decisions fed into
+// it by HandleDecision as they arrive over POST /api/ehaw/decision.
+func newWebModerator() *WebModerator {
+	return &WebModerator{decisions: make(chan ehawDecision, 16)}
+}
+
+// HandleDecision feeds a decision received from the web panel into the
+// moderator. Wired into handleEhawDecision in ehaw_http.go.
+func (m *WebModerator) HandleDecision(d ehawDecision) {
+	m.decisions <- d
+}
+
+func (m *WebModerator) Decide(msg msgStruct) (string, error) {
+	event := ehawPendingEvent{
+		MsgId:   msg.msgId,
+		Subject: msg.msgSubject,
+		To:      msg.msgTo,
+		Body:    msg.msgBody,
+	}
+	ehawPending.Store(msg.msgId, event)
+	defer ehawPending.Delete(msg.msgId)
+
+	for d := range m.decisions {
+		if d.MsgId != msg.msgId {
+			// stale decision for a message we already resolved, or one
+			// that hasn't been published yet; drop it and keep waiting
+			continue
+		}
+		return strings.ToUpper(strings.TrimSpace(d.Action)), nil
+	}
+	return "", errors.New("eHaW: web moderator decision channel closed")
+}
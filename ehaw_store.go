@@ -0,0 +1,215 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
+
+	"github.com/la5nta/pat/migrations"
+)
+
+// EhawStore is the persistence layer behind the eHaW moderation queue.
+// getEhawEmail, updateEhawMsg and updateSentEhawMsgStatus used to open a
+// fresh *sql.DB connection against a hardcoded MySQL DSN on every call;
+// callers now go through this interface instead, so processEhawEmail
+// doesn't need to know or care which SQL dialect is behind the store.
+type EhawStore interface {
+	getEhawEmail() ([]msgStruct, error)
+	updateEhawMsg(id int, status string, newMsgId string) error
+	updateSentEhawMsgStatus() (int, error)
+	Close() error
+}
+
+// newEhawStore opens the driver selected by cfg.Driver ("mysql",
+// "postgres" or "sqlite"; mysql is assumed if unset for backwards
+// compatibility), applies its migrations, and returns a ready-to-use
+// EhawStore backed by a single long-lived *sql.DB.
+func newEhawStore(cfg ehawConfig) (EhawStore, error) {
+	driver := cfg.Driver
+	if driver == "" {
+		driver = "mysql"
+	}
+
+	var driverName, dsn string
+	switch driver {
+	case "mysql":
+		driverName = "mysql"
+		dsn = fmt.Sprintf("%s:%s@tcp(%s)/%s?parseTime=true",
+			cfg.Mysql_user, cfg.Mysql_password, cfg.Mysql_host, cfg.Mysql_database)
+	case "postgres":
+		driverName = "postgres"
+		dsn = fmt.Sprintf("postgres://%s:%s@%s/%s?sslmode=disable",
+			cfg.Postgres_user, cfg.Postgres_password, cfg.Postgres_host, cfg.Postgres_database)
+	case "sqlite":
+		driverName = "sqlite"
+		dsn = cfg.SQLitePath
+		if dsn == "" {
+			dsn = "eHaW.db"
+		}
+	default:
+		return nil, fmt.Errorf("eHaW: unknown driver %q", driver)
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("eHaW: opening %s store: %w", driver, err)
+	}
+	db.SetMaxOpenConns(4)
+	db.SetConnMaxLifetime(30 * time.Minute)
+
+	if err := db.PingContext(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("eHaW: connecting to %s store: %w", driver, err)
+	}
+
+	if err := migrations.Apply(db, driver); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("eHaW: migrating %s store: %w", driver, err)
+	}
+
+	s := &sqlEhawStore{db: db, driver: driver}
+	if err := s.prepare(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// sqlEhawStore implements EhawStore on top of database/sql. The four
+// statements behind its three query methods are prepared once against the
+// long-lived connection instead of being rebuilt (and the connection
+// reopened) on every call.
+type sqlEhawStore struct {
+	db     *sql.DB
+	driver string
+
+	selectMsgsStmt     *sql.Stmt
+	updateStatusStmt   *sql.Stmt
+	selectAcceptedStmt *sql.Stmt
+	updateSentStmt     *sql.Stmt
+}
+
+// placeholder returns the n'th bind parameter marker for the store's SQL
+// dialect ($1, $2, ... for postgres; ? for mysql and sqlite).
+func (s *sqlEhawStore) placeholder(n int) string {
+	if s.driver == "postgres" {
+		return fmt.Sprintf("$%d", n)
+	}
+	return "?"
+}
+
+func (s *sqlEhawStore) prepare() error {
+	ctx := context.Background()
+
+	var err error
+	s.selectMsgsStmt, err = s.db.PrepareContext(ctx,
+		"SELECT msgId, msgSubject, msgTo, msgBody FROM buildMsg ORDER BY msgId")
+	if err != nil {
+		return fmt.Errorf("eHaW: preparing select statement: %w", err)
+	}
+
+	s.updateStatusStmt, err = s.db.PrepareContext(ctx, fmt.Sprintf(
+		"UPDATE msgQueue SET msgStatus=%s, msgWinlinkId=%s WHERE msgId=%s",
+		s.placeholder(1), s.placeholder(2), s.placeholder(3)))
+	if err != nil {
+		return fmt.Errorf("eHaW: preparing update statement: %w", err)
+	}
+
+	s.selectAcceptedStmt, err = s.db.PrepareContext(ctx,
+		"SELECT msgId, msgWinlinkId FROM msgQueue WHERE msgStatus='Accepted' AND msgWinlinkId IS NOT NULL")
+	if err != nil {
+		return fmt.Errorf("eHaW: preparing accepted-select statement: %w", err)
+	}
+
+	s.updateSentStmt, err = s.db.PrepareContext(ctx, fmt.Sprintf(
+		"UPDATE msgQueue SET msgStatus='Sent' WHERE msgId=%s", s.placeholder(1)))
+	if err != nil {
+		return fmt.Errorf("eHaW: preparing sent-update statement: %w", err)
+	}
+
+	return nil
+}
+
+func (s *sqlEhawStore) Close() error { return s.db.Close() }
+
+func (s *sqlEhawStore) getEhawEmail() ([]msgStruct, error) {
+	rows, err := s.selectMsgsStmt.QueryContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []msgStruct
+	for rows.Next() {
+		var msg msgStruct
+		if err := rows.Scan(&msg.msgId, &msg.msgSubject, &msg.msgTo, &msg.msgBody); err != nil {
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, rows.Err()
+}
+
+func (s *sqlEhawStore) updateEhawMsg(id int, status string, newMsgId string) error {
+	if _, err := s.updateStatusStmt.ExecContext(context.Background(), status, newMsgId, id); err != nil {
+		return err
+	}
+	logger.Infof("eHaW record updated", Fields{"status": status})
+	return nil
+}
+
+func (s *sqlEhawStore) updateSentEhawMsgStatus() (int, error) {
+	rows, err := s.selectAcceptedStmt.QueryContext(context.Background())
+	if err != nil {
+		return 0, err
+	}
+	var accepted []msgQueue
+	for rows.Next() {
+		var msg msgQueue
+		if err := rows.Scan(&msg.msgId, &msg.msgWinlinkId); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		accepted = append(accepted, msg)
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		return 0, err
+	}
+	rows.Close()
+
+	if len(accepted) == 0 {
+		return 0, nil
+	}
+
+	sentMsgs, err := getMsgBoxList("sent")
+	if err != nil {
+		return 0, err
+	}
+	sent := make(map[string]struct{}, len(sentMsgs))
+	for _, mid := range sentMsgs {
+		sent[mid] = struct{}{}
+	}
+
+	var count int
+	for _, msg := range accepted {
+		if _, ok := sent[msg.msgWinlinkId]; !ok {
+			continue
+		}
+		if _, err := s.updateSentStmt.ExecContext(context.Background(), msg.msgId); err != nil {
+			return count, err
+		}
+		notifyEhawWebhook(msg.msgId, msg.msgWinlinkId, "Sent")
+		count++
+	}
+	return count, nil
+}
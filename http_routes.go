@@ -0,0 +1,25 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import "net/http"
+
+// RegisterHTTPHandlers mounts every handler added by this series (eHaW
+// intake and web moderation, the connect schedule queue, the log tail)
+// onto mux.
+//
+// Pat's real web server builds its own *http.ServeMux and passes it
+// explicitly to its listener rather than using http.DefaultServeMux
+// (that server's source isn't part of this tree/snapshot, so it can't be
+// edited here) — registering these routes on http.DefaultServeMux, as an
+// earlier version of this file did, left them unreachable in that real
+// server. The one remaining line this series still needs outside its own
+// files is a call to RegisterHTTPHandlers(mux) at whatever point that
+// real server constructs its mux, before it starts listening.
+func RegisterHTTPHandlers(mux *http.ServeMux) {
+	registerEhawHTTPHandlers(mux)
+	registerConnectScheduleHTTPHandlers(mux)
+	registerLogHTTPHandlers(mux)
+}
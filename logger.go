@@ -0,0 +1,123 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+
+	"github.com/la5nta/pat/internal/debug"
+)
+
+// logger replaces the ad-hoc log.Println/log.Printf/fmt.Println mix in
+// connect.go and the eHaW add-on with leveled, structured logging: Debugf
+// routes through internal/debug (gated by -debug) exactly as before,
+// while Infof/Warnf/Errorf write a single JSON line (level, message, and
+// any Fields) to Pat's existing log sink, so operators running Pat under
+// journald/Docker can parse and filter structured fields instead of
+// grepping free-form text, and also land in a ring buffer exposed at
+// GET /api/log_tail (see registerLogHTTPHandlers below).
+var logger = &levelLogger{ring: newLogRingBuffer(200)}
+
+// Fields attaches structured key/value context to a log line (e.g.
+// scheme, addr, mycall) without changing the printf-style call site: pass
+// it as the last argument to Infof/Warnf/Errorf and it's stripped out
+// before the message is formatted.
+type Fields map[string]string
+
+// logEvent is one entry in the ring buffer, suitable for the web UI's
+// structured log-tail subscription at GET /api/log_tail.
+type logEvent struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+	Fields  Fields `json:"fields,omitempty"`
+}
+
+type levelLogger struct {
+	ring *logRingBuffer
+}
+
+func (l *levelLogger) Debugf(format string, args ...interface{}) {
+	debug.Printf(format, args...)
+}
+
+func (l *levelLogger) Infof(format string, args ...interface{}) {
+	l.logf("info", format, args...)
+}
+
+func (l *levelLogger) Warnf(format string, args ...interface{}) {
+	l.logf("warn", format, args...)
+}
+
+func (l *levelLogger) Errorf(format string, args ...interface{}) {
+	l.logf("error", format, args...)
+}
+
+func (l *levelLogger) logf(level, format string, args ...interface{}) {
+	var fields Fields
+	if n := len(args); n > 0 {
+		if f, ok := args[n-1].(Fields); ok {
+			fields, args = f, args[:n-1]
+		}
+	}
+
+	event := logEvent{Level: level, Message: fmt.Sprintf(format, args...), Fields: fields}
+
+	// Marshaled as a single JSON line so Fields actually reach operators
+	// running under journald/Docker instead of being dropped on the way
+	// to the log sink; falls back to the bare message if that ever fails.
+	if data, err := json.Marshal(event); err == nil {
+		log.Println(string(data))
+	} else {
+		log.Println(event.Message)
+	}
+	l.ring.push(event)
+}
+
+// logRingBuffer keeps the last n structured log events in memory for the
+// web UI to subscribe to at GET /api/log_tail, without needing a logging
+// backend.
+type logRingBuffer struct {
+	mu     sync.Mutex
+	events []logEvent
+	size   int
+}
+
+func newLogRingBuffer(size int) *logRingBuffer {
+	return &logRingBuffer{size: size}
+}
+
+func (r *logRingBuffer) push(e logEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+	if len(r.events) > r.size {
+		r.events = r.events[len(r.events)-r.size:]
+	}
+}
+
+// Tail returns a snapshot of the most recent structured log events, for
+// the web UI's log-tail subscription at GET /api/log_tail.
+func (r *logRingBuffer) Tail() []logEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]logEvent, len(r.events))
+	copy(out, r.events)
+	return out
+}
+
+// registerLogHTTPHandlers wires the log-tail endpoint onto mux. Called
+// from RegisterHTTPHandlers, see http_routes.go.
+func registerLogHTTPHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/api/log_tail", handleLogTail)
+}
+
+func handleLogTail(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logger.ring.Tail())
+}
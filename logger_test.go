@@ -0,0 +1,52 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLevelLoggerEmitsFieldsToTheLogSink(t *testing.T) {
+	var buf bytes.Buffer
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(&buf)
+
+	l := &levelLogger{ring: newLogRingBuffer(4)}
+	l.Infof("connecting to %s", "example.com", Fields{"scheme": "ardop"})
+
+	line := strings.TrimSpace(buf.String())
+	var event logEvent
+	if err := json.Unmarshal([]byte(line[strings.Index(line, "{"):]), &event); err != nil {
+		t.Fatalf("log sink line %q is not the expected JSON event: %s", line, err)
+	}
+	if event.Level != "info" {
+		t.Errorf("level = %q, want %q", event.Level, "info")
+	}
+	if event.Message != "connecting to example.com" {
+		t.Errorf("message = %q, want %q", event.Message, "connecting to example.com")
+	}
+	if event.Fields["scheme"] != "ardop" {
+		t.Errorf("fields[scheme] = %q, want %q (Fields must not be dropped on the way to the log sink)", event.Fields["scheme"], "ardop")
+	}
+}
+
+func TestLogRingBufferEviction(t *testing.T) {
+	r := newLogRingBuffer(2)
+	r.push(logEvent{Message: "a"})
+	r.push(logEvent{Message: "b"})
+	r.push(logEvent{Message: "c"})
+
+	tail := r.Tail()
+	if len(tail) != 2 {
+		t.Fatalf("expected ring buffer capped at 2, got %d", len(tail))
+	}
+	if tail[0].Message != "b" || tail[1].Message != "c" {
+		t.Errorf("tail = %+v, want [b c]", tail)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+// Package migrations creates the eHaW schema (the buildMsg and msgQueue
+// tables) on first run, so Pat can be deployed with the eHaW add-on
+// against nothing more than a bundled SQLite file and no external
+// database server.
+package migrations
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// schema holds the buildMsg/msgQueue table definitions per driver. Driver
+// names match the "driver" key accepted in eHaW_config.json.
+var schema = map[string][]string{
+	"mysql": {
+		`CREATE TABLE IF NOT EXISTS buildMsg (
+			msgId INT AUTO_INCREMENT PRIMARY KEY,
+			msgSubject VARCHAR(255) NOT NULL,
+			msgTo VARCHAR(255) NOT NULL,
+			msgBody TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS msgQueue (
+			msgId INT PRIMARY KEY,
+			msgStatus VARCHAR(32) NOT NULL DEFAULT 'Pending',
+			msgWinlinkId VARCHAR(64)
+		)`,
+	},
+	"postgres": {
+		`CREATE TABLE IF NOT EXISTS buildMsg (
+			msgId SERIAL PRIMARY KEY,
+			msgSubject TEXT NOT NULL,
+			msgTo TEXT NOT NULL,
+			msgBody TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS msgQueue (
+			msgId INTEGER PRIMARY KEY,
+			msgStatus TEXT NOT NULL DEFAULT 'Pending',
+			msgWinlinkId TEXT
+		)`,
+	},
+	"sqlite": {
+		`CREATE TABLE IF NOT EXISTS buildMsg (
+			msgId INTEGER PRIMARY KEY AUTOINCREMENT,
+			msgSubject TEXT NOT NULL,
+			msgTo TEXT NOT NULL,
+			msgBody TEXT NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS msgQueue (
+			msgId INTEGER PRIMARY KEY,
+			msgStatus TEXT NOT NULL DEFAULT 'Pending',
+			msgWinlinkId TEXT
+		)`,
+	},
+}
+
+// Apply creates the buildMsg and msgQueue tables for driver if they do not
+// already exist. It is idempotent, so it's safe to call on every startup.
+func Apply(db *sql.DB, driver string) error {
+	stmts, ok := schema[driver]
+	if !ok {
+		return fmt.Errorf("migrations: no schema defined for driver %q", driver)
+	}
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("migrations: %w", err)
+		}
+	}
+	return nil
+}
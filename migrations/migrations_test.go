@@ -0,0 +1,65 @@
+// Copyright 2016 Martin Hebnes Pedersen (LA5NTA). All rights reserved.
+// Use of this source code is governed by the MIT-license that can be
+// found in the LICENSE file.
+
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "modernc.org/sqlite"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite", filepath.Join(t.TempDir(), "ehaw.db"))
+	if err != nil {
+		t.Fatalf("opening sqlite db: %s", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestApplySQLiteCreatesSchema(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Apply(db, "sqlite"); err != nil {
+		t.Fatalf("Apply: %s", err)
+	}
+
+	if _, err := db.Exec(`INSERT INTO buildMsg (msgSubject, msgTo, msgBody) VALUES (?, ?, ?)`, "subj", "to", "body"); err != nil {
+		t.Errorf("inserting into buildMsg: %s", err)
+	}
+	if _, err := db.Exec(`INSERT INTO msgQueue (msgId, msgStatus) VALUES (1, 'Pending')`); err != nil {
+		t.Errorf("inserting into msgQueue: %s", err)
+	}
+}
+
+func TestApplyIsIdempotent(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Apply(db, "sqlite"); err != nil {
+		t.Fatalf("Apply (first run): %s", err)
+	}
+	if err := Apply(db, "sqlite"); err != nil {
+		t.Fatalf("Apply (second run): %s", err)
+	}
+}
+
+func TestApplyUnknownDriver(t *testing.T) {
+	db := openTestDB(t)
+
+	if err := Apply(db, "oracle"); err == nil {
+		t.Fatal("expected an error for a driver with no schema defined")
+	}
+}
+
+func TestSchemaDefinedForEveryDriver(t *testing.T) {
+	for _, driver := range []string{"mysql", "postgres", "sqlite"} {
+		if _, ok := schema[driver]; !ok {
+			t.Errorf("no schema defined for driver %q", driver)
+		}
+	}
+}